@@ -15,10 +15,12 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/golang/gddo/httputil"
 	"github.com/gorilla/mux"
+	"github.com/ibuildthecloud/rancher-metadata/query"
 	yaml "gopkg.in/yaml.v2"
 )
 
@@ -43,12 +45,19 @@ var (
 	listen       = flag.String("listen", ":80", "Address to listen to (TCP)")
 	listenReload = flag.String("listenReload", "127.0.0.1:8112", "Address to listen to for reload requests (TCP)")
 	answersFile  = flag.String("answers", "./answers.yaml", "File containing the answers to respond with")
+	answersDir   = flag.String("answers-dir", "", "Directory tree of per-version/per-client answer files, watched incrementally; takes precedence over -answers if set")
 	logFile      = flag.String("log", "", "Log file")
 	pidFile      = flag.String("pid-file", "", "PID to write to")
 	subscribe    = flag.Bool("subscribe", false, "Subscribe to Rancher events")
+	waitTimeout  = flag.Duration("wait-timeout", 60*time.Second, "Maximum time to hold open a long-poll or SSE watch request with no change before returning")
 
 	router  = mux.NewRouter()
 	answers Versions
+	store   AnswersStore
+
+	mainServer   *http.Server
+	reloadServer *http.Server
+	tlsServer    *http.Server
 
 	VERSION    string
 	loading    = false
@@ -64,24 +73,33 @@ func main() {
 	}
 
 	log.Infof("Starting rancher-metadata %s", VERSION)
-	err := loadAnswers()
-	if err != nil {
-		log.Fatal("Cannot startup without a valid Answers file")
+
+	if *answersDir != "" {
+		ds, err := newDirStore(*answersDir)
+		if err != nil {
+			log.Fatalf("Cannot startup without a valid answers directory: %v", err)
+		}
+		store = ds
+	} else {
+		store = newFileStore(*answersFile)
+		if err := loadAnswers(); err != nil {
+			log.Fatal("Cannot startup without a valid Answers file")
+		}
 	}
 
 	watchSignals()
-	watchHttp()
+	reloadServer = watchHttp()
 
 	router.HandleFunc("/favicon.ico", http.NotFound)
-	router.HandleFunc("/", root).
+	router.HandleFunc("/", instrument("root", root)).
 		Methods("GET", "HEAD").
 		Name("Root")
 
-	router.HandleFunc("/{version}", metadata).
+	router.HandleFunc("/{version}", instrument("metadata", metadata)).
 		Methods("GET", "HEAD").
 		Name("Version")
 
-	router.HandleFunc("/{version}/{key:.*}", metadata).
+	router.HandleFunc("/{version}/{key:.*}", instrument("metadata", metadata)).
 		Methods("GET", "HEAD").
 		Name("Metadata")
 
@@ -97,13 +115,22 @@ func main() {
 		}
 	}
 
+	tlsServer = startTLSListener()
+	watchShutdown()
+
+	mainServer = &http.Server{Addr: *listen, Handler: router}
+
 	log.Info("Listening on ", *listen)
-	log.Fatal(http.ListenAndServe(*listen, router))
+	if err := mainServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
 }
 
 func parseFlags() {
 	flag.Parse()
 
+	maxWait = *waitTimeout
+
 	if *debug {
 		log.SetLevel(log.DebugLevel)
 	}
@@ -125,7 +152,15 @@ func parseFlags() {
 }
 
 func loadAnswers() error {
-	_, err := loadAnswersFromFile(*answersFile)
+	err := store.Reload()
+
+	if certErr := loadCertificate(); certErr != nil {
+		log.Errorf("Failed to reload TLS certificate: %v", certErr)
+		if err == nil {
+			err = certErr
+		}
+	}
+
 	return err
 }
 
@@ -134,6 +169,7 @@ func loadAnswersFromFile(file string) (Versions, error) {
 	loading = true
 	neu, err := ParseAnswers(file)
 	if err == nil {
+		clients := 0
 		for _, data := range neu {
 			defaults, ok := data[DEFAULT_KEY]
 			if ok {
@@ -144,14 +180,23 @@ func loadAnswersFromFile(file string) (Versions, error) {
 					mergeDefaults(&data, defaultsMap)
 				}
 			}
+			for client := range data {
+				if client == DEFAULT_KEY {
+					continue
+				}
+				clients++
+			}
 		}
 
 		answers = neu
 		loading = false
+		registeredClients.Set(float64(clients))
+		bumpRevision()
 		log.Infof("Loaded answers")
 	} else {
 		log.Errorf("Failed to load answers: %v", err)
 	}
+	recordReload(file, err)
 	return answers, err
 }
 
@@ -190,13 +235,22 @@ func watchSignals() {
 	}()
 }
 
-func watchHttp() {
+func watchHttp() *http.Server {
 	reloadRouter := mux.NewRouter()
 	reloadRouter.HandleFunc("/favicon.ico", http.NotFound)
-	reloadRouter.HandleFunc("/v1/reload", httpReload).Methods("POST")
+	reloadRouter.HandleFunc("/v1/reload", instrument("reload", httpReload)).Methods("POST")
+	registerMetrics(reloadRouter)
+
+	server := &http.Server{Addr: *listenReload, Handler: reloadRouter}
 
 	log.Info("Listening for Reload on ", *listenReload)
-	go http.ListenAndServe(*listenReload, reloadRouter)
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Error("Reload server stopped: ", err)
+		}
+	}()
+
+	return server
 }
 
 func httpReload(w http.ResponseWriter, req *http.Request) {
@@ -235,10 +289,8 @@ func contentType(req *http.Request) int {
 func root(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
-	log.WithFields(log.Fields{"client": requestIp(req), "version": "root"}).Infof("OK: %s", "/")
-
 	m := make(map[string]interface{})
-	for _, k := range answers.Versions() {
+	for _, k := range store.Versions() {
 		url, err := router.Get("Version").URL("version", k)
 		if err == nil {
 			m[k] = (*url).String()
@@ -268,12 +320,12 @@ func metadata(w http.ResponseWriter, req *http.Request) {
 	clientIp := requestIp(req)
 
 	version := vars["version"]
-	_, ok := answers[version]
-	if !ok {
+	versions := store.Versions()
+	if !hasVersion(versions, version) {
 		// If a `latest` key is not provided, pick the ASCII-betically highest version and call it that.
 		if version == "latest" {
 			version = ""
-			for _, k := range answers.Versions() {
+			for _, k := range versions {
 				if k > version {
 					version = k
 				}
@@ -300,16 +352,45 @@ func metadata(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	log.WithFields(log.Fields{"version": version, "client": clientIp}).Debugf("Searching for: %s", displayKey)
-	val, ok := answers.Matching(version, clientIp, pathSegments)
+	var q *query.Query
+	if expr := req.URL.Query().Get("query"); expr != "" {
+		q, err = query.Parse(expr)
+		if err != nil {
+			respondError(w, req, "Invalid query: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 
-	if ok {
-		log.WithFields(log.Fields{"version": version, "client": clientIp}).Infof("OK: %s", displayKey)
-		respondSuccess(w, req, val)
-	} else {
-		log.WithFields(log.Fields{"version": version, "client": clientIp}).Infof("Error: %s", displayKey)
+	if wantsWatch(req) {
+		watchMetadata(w, req, version, clientIp, pathSegments, displayKey, q)
+		return
+	}
+
+	log.WithFields(log.Fields{"version": version, "client": clientIp}).Debugf("Searching for: %s", displayKey)
+	val, ok, err := resolveValue(version, clientIp, pathSegments, q)
+	if err != nil {
+		respondError(w, req, "Invalid query: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !ok {
 		respondError(w, req, "Not found", http.StatusNotFound)
+		return
+	}
+
+	respondSuccess(w, req, val)
+}
+
+// resolveValue looks up path under version/clientIp and, if q is set,
+// projects the result through it. Shared by the synchronous and the watch
+// (long-poll/SSE) code paths so ?query= is applied identically either way.
+func resolveValue(version, clientIp string, pathSegments []string, q *query.Query) (interface{}, bool, error) {
+	val, ok := store.Get(version, clientIp, pathSegments)
+	if !ok || q == nil {
+		return val, ok, nil
 	}
+
+	val, ok = q.Evaluate(val)
+	return val, ok, nil
 }
 
 func respondError(w http.ResponseWriter, req *http.Request, msg string, statusCode int) {
@@ -434,6 +515,15 @@ func respondYAML(w http.ResponseWriter, req *http.Request, val interface{}) {
 	}
 }
 
+func hasVersion(versions []string, version string) bool {
+	for _, v := range versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
 func requestIp(req *http.Request) string {
 	if *enableXff {
 		clientIp := req.Header.Get("X-Forwarded-For")
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	requestCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rancher_metadata_requests_total",
+		Help: "Total number of metadata requests served, by version, status and content type.",
+	}, []string{"version", "status", "content_type"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "rancher_metadata_request_duration_seconds",
+		Help:    "Request latency in seconds, by version and content type.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"version", "content_type"})
+
+	reloadCount = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "rancher_metadata_reloads_total",
+		Help: "Total number of answers file reloads attempted.",
+	})
+
+	reloadErrorCount = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "rancher_metadata_reload_errors_total",
+		Help: "Total number of answers file reloads that failed to parse.",
+	})
+
+	lastReloadTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "rancher_metadata_last_reload_timestamp_seconds",
+		Help: "Unix timestamp of the last successful answers reload.",
+	})
+
+	answersFileMtime = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "rancher_metadata_answers_file_mtime_seconds",
+		Help: "Modification time of the answers file as of the last reload attempt.",
+	})
+
+	registeredClients = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "rancher_metadata_registered_clients",
+		Help: "Number of client IPs registered in the current answers, summed across versions.",
+	})
+
+	activeWatchers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "rancher_metadata_active_watchers",
+		Help: "Number of long-poll/SSE watch requests currently blocked waiting for a change.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		requestCount,
+		requestDuration,
+		reloadCount,
+		reloadErrorCount,
+		lastReloadTimestamp,
+		answersFileMtime,
+		registeredClients,
+		activeWatchers,
+	)
+}
+
+// statusRecorder wraps a ResponseWriter so the instrumenting middleware can
+// observe the status code and byte count a handler actually wrote, while
+// still passing through Flush for the SSE watch handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// instrument wraps a route handler with Prometheus metrics and a single
+// structured access-log line, replacing the ad-hoc logging that used to be
+// sprinkled through root and metadata.
+func instrument(name string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next(rec, req)
+
+		version := mux.Vars(req)["version"]
+		if version == "" {
+			version = name
+		}
+		ct := contentTypeName(req)
+		duration := time.Since(start)
+
+		requestCount.WithLabelValues(version, strconv.Itoa(rec.status), ct).Inc()
+		requestDuration.WithLabelValues(version, ct).Observe(duration.Seconds())
+
+		log.WithFields(log.Fields{
+			"client":       requestIp(req),
+			"version":      version,
+			"path":         req.URL.Path,
+			"status":       rec.status,
+			"bytes":        rec.bytes,
+			"duration_ms":  duration.Milliseconds(),
+			"content_type": ct,
+		}).Info("request")
+	}
+}
+
+func contentTypeName(req *http.Request) string {
+	switch contentType(req) {
+	case ContentJSON:
+		return "json"
+	case ContentYAML:
+		return "yaml"
+	default:
+		return "text"
+	}
+}
+
+// recordReload updates the reload/parse-error/mtime gauges for one
+// loadAnswersFromFile attempt.
+func recordReload(file string, err error) {
+	reloadCount.Inc()
+
+	if info, statErr := os.Stat(file); statErr == nil {
+		answersFileMtime.Set(float64(info.ModTime().Unix()))
+	}
+
+	if err != nil {
+		reloadErrorCount.Inc()
+		return
+	}
+
+	lastReloadTimestamp.Set(float64(time.Now().Unix()))
+}
+
+func registerMetrics(router *mux.Router) {
+	router.Handle("/metrics", promhttp.Handler()).Methods("GET")
+}
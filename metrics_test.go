@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestContentTypeName(t *testing.T) {
+	req := httptest.NewRequest("GET", "/latest/self", nil)
+	req.Header.Set("Accept", "application/json")
+	if ct := contentTypeName(req); ct != "json" {
+		t.Fatalf("expected json, got %s", ct)
+	}
+}
+
+func TestStatusRecorderCapturesStatusAndBytes(t *testing.T) {
+	rec := httptest.NewRecorder()
+	sr := &statusRecorder{ResponseWriter: rec, status: http.StatusOK}
+
+	sr.WriteHeader(http.StatusNotFound)
+	n, err := sr.Write([]byte("hello"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != 5 {
+		t.Fatalf("expected to write 5 bytes, wrote %d", n)
+	}
+	if sr.status != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", sr.status)
+	}
+	if sr.bytes != 5 {
+		t.Fatalf("expected 5 bytes recorded, got %d", sr.bytes)
+	}
+}
+
+func TestStatusRecorderDefaultsStatusOnWrite(t *testing.T) {
+	rec := httptest.NewRecorder()
+	sr := &statusRecorder{ResponseWriter: rec}
+
+	sr.Write([]byte("x"))
+	if sr.status != http.StatusOK {
+		t.Fatalf("expected an implicit 200 status on the first write, got %d", sr.status)
+	}
+}
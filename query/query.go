@@ -0,0 +1,322 @@
+// Package query implements a small JSONPath/JMESPath-flavored expression
+// language for projecting into the interface{} trees that ParseAnswers
+// produces (nested map[string]interface{} / []interface{}). It's not a full
+// JMESPath implementation - just enough to support field access, numeric
+// indexing, `[*]` wildcards and `[?key=='value']` filters, e.g.:
+//
+//	containers[?state=='running'].name
+package query
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MagicArrayKey mirrors the main package's MAGIC_ARRAY_KEY: indexing into an
+// array of maps by a non-numeric name matches it against this field on each
+// element, the same lookup Versions.Matching already does for paths.
+const MagicArrayKey = "name"
+
+// projected marks a value as the result of a wildcard or filter step: later
+// field/index steps apply to every element instead of to the list itself.
+type projected []interface{}
+
+type step interface {
+	apply(v interface{}) (interface{}, error)
+}
+
+// Query is a compiled expression, safe to evaluate against many trees.
+type Query struct {
+	steps []step
+}
+
+// Parse compiles expr into a Query.
+func Parse(expr string) (*Query, error) {
+	q := &Query{}
+
+	for _, part := range splitSteps(expr) {
+		if part == "" {
+			continue
+		}
+
+		name, bracket, err := splitBracket(part)
+		if err != nil {
+			return nil, fmt.Errorf("query: %v", err)
+		}
+
+		if name != "" {
+			q.steps = append(q.steps, fieldStep{name})
+		}
+
+		if bracket != "" {
+			s, err := parseBracket(bracket)
+			if err != nil {
+				return nil, fmt.Errorf("query: %v", err)
+			}
+			q.steps = append(q.steps, s)
+		}
+	}
+
+	return q, nil
+}
+
+// Eval parses and evaluates expr against root in one call.
+func Eval(expr string, root interface{}) (interface{}, bool, error) {
+	q, err := Parse(expr)
+	if err != nil {
+		return nil, false, err
+	}
+	val, ok := q.Evaluate(root)
+	return val, ok, nil
+}
+
+// Evaluate runs the compiled query against root. It returns false only when
+// a step couldn't resolve at all (a missing field, an out-of-range index) -
+// a wildcard or filter step that legitimately matches zero elements is a
+// valid result and comes back as ok=true with an empty slice, the same way
+// `containers[?state=='running']` should be a 200 with `[]` rather than a
+// 404 when nothing is running.
+func (q *Query) Evaluate(root interface{}) (interface{}, bool) {
+	var cur interface{} = root
+
+	for _, s := range q.steps {
+		next, err := s.apply(cur)
+		if err != nil || next == nil {
+			return nil, false
+		}
+		cur = next
+	}
+
+	if p, ok := cur.(projected); ok {
+		return []interface{}(p), true
+	}
+	return cur, true
+}
+
+func mapProjection(v projected, f func(interface{}) (interface{}, error)) projected {
+	out := make(projected, 0, len(v))
+	for _, item := range v {
+		r, err := f(item)
+		if err != nil || r == nil {
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+type fieldStep struct {
+	name string
+}
+
+func (s fieldStep) apply(v interface{}) (interface{}, error) {
+	if p, ok := v.(projected); ok {
+		return mapProjection(p, s.applyOne), nil
+	}
+	return s.applyOne(v)
+}
+
+func (s fieldStep) applyOne(v interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		val, ok := t[s.name]
+		if !ok {
+			return nil, nil
+		}
+		return val, nil
+	case []interface{}:
+		return findByName(t, s.name)
+	default:
+		return nil, fmt.Errorf("cannot select field %q on %T", s.name, v)
+	}
+}
+
+func findByName(arr []interface{}, name string) (interface{}, error) {
+	for _, item := range arr {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if n, ok := m[MagicArrayKey]; ok && fmt.Sprintf("%v", n) == name {
+			return item, nil
+		}
+	}
+	return nil, nil
+}
+
+type indexStep struct {
+	index int
+}
+
+func (s indexStep) apply(v interface{}) (interface{}, error) {
+	if p, ok := v.(projected); ok {
+		return mapProjection(p, s.applyOne), nil
+	}
+	return s.applyOne(v)
+}
+
+func (s indexStep) applyOne(v interface{}) (interface{}, error) {
+	arr, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("cannot index %T with [%d]", v, s.index)
+	}
+
+	i := s.index
+	if i < 0 {
+		i += len(arr)
+	}
+	if i < 0 || i >= len(arr) {
+		return nil, nil
+	}
+	return arr[i], nil
+}
+
+type wildcardStep struct{}
+
+func (wildcardStep) apply(v interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case []interface{}:
+		return projected(append([]interface{}{}, t...)), nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		out := make(projected, 0, len(t))
+		for _, k := range keys {
+			out = append(out, t[k])
+		}
+		return out, nil
+	case projected:
+		out := make(projected, 0, len(t))
+		for _, item := range t {
+			if arr, ok := item.([]interface{}); ok {
+				out = append(out, arr...)
+			} else {
+				out = append(out, item)
+			}
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("cannot wildcard %T", v)
+	}
+}
+
+type filterStep struct {
+	key    string
+	value  string
+	negate bool
+}
+
+func (s filterStep) apply(v interface{}) (interface{}, error) {
+	var arr []interface{}
+	switch t := v.(type) {
+	case []interface{}:
+		arr = t
+	case projected:
+		arr = []interface{}(t)
+	default:
+		return nil, fmt.Errorf("cannot filter %T", v)
+	}
+
+	out := make(projected, 0, len(arr))
+	for _, item := range arr {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		val, ok := m[s.key]
+		matches := ok && fmt.Sprintf("%v", val) == s.value
+		if s.negate {
+			matches = !matches
+		}
+		if matches {
+			out = append(out, item)
+		}
+	}
+
+	return out, nil
+}
+
+// splitSteps splits expr on '.' that aren't inside a [...] subscript.
+func splitSteps(expr string) []string {
+	var parts []string
+	depth := 0
+	start := 0
+
+	for i, r := range expr {
+		switch r {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case '.':
+			if depth == 0 {
+				parts = append(parts, expr[start:i])
+				start = i + 1
+			}
+		}
+	}
+	parts = append(parts, expr[start:])
+
+	return parts
+}
+
+// splitBracket splits a single step like `containers[?state=='running']`
+// into its field name ("containers") and subscript content
+// ("?state=='running'").
+func splitBracket(part string) (name, bracket string, err error) {
+	idx := strings.IndexByte(part, '[')
+	if idx < 0 {
+		return part, "", nil
+	}
+
+	if !strings.HasSuffix(part, "]") {
+		return "", "", fmt.Errorf("unbalanced [ in %q", part)
+	}
+
+	return part[:idx], part[idx+1 : len(part)-1], nil
+}
+
+func parseBracket(content string) (step, error) {
+	if content == "*" {
+		return wildcardStep{}, nil
+	}
+
+	if strings.HasPrefix(content, "?") {
+		return parseFilter(content[1:])
+	}
+
+	i, err := strconv.Atoi(content)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported subscript [%s]", content)
+	}
+	return indexStep{i}, nil
+}
+
+func parseFilter(expr string) (step, error) {
+	op := "=="
+	idx := strings.Index(expr, op)
+	negate := false
+
+	if idx < 0 {
+		op = "!="
+		idx = strings.Index(expr, op)
+		negate = true
+	}
+
+	if idx < 0 {
+		return nil, fmt.Errorf("unsupported filter [?%s]", expr)
+	}
+
+	key := strings.TrimSpace(expr[:idx])
+	value := strings.TrimSpace(expr[idx+len(op):])
+	value = strings.Trim(value, "'\"")
+
+	return filterStep{key: key, value: value, negate: negate}, nil
+}
@@ -0,0 +1,194 @@
+package query
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFieldAccess(t *testing.T) {
+	data := map[string]interface{}{
+		"self": map[string]interface{}{
+			"service": map[string]interface{}{
+				"name": "web",
+			},
+		},
+	}
+
+	val, ok, err := Eval("self.service.name", data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ok {
+		t.Fatalf("expected ok")
+	}
+	if val != "web" {
+		t.Fatalf("expected %q, got %v", "web", val)
+	}
+}
+
+func TestMissingFieldIsNotFound(t *testing.T) {
+	data := map[string]interface{}{"foo": "bar"}
+
+	_, ok, err := Eval("nope", data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ok {
+		t.Fatalf("expected not-found for a missing field")
+	}
+}
+
+func TestFilterProjectsMatchingNames(t *testing.T) {
+	data := map[string]interface{}{
+		"containers": []interface{}{
+			map[string]interface{}{"name": "web", "state": "running"},
+			map[string]interface{}{"name": "db", "state": "stopped"},
+			map[string]interface{}{"name": "cache", "state": "running"},
+		},
+	}
+
+	val, ok, err := Eval("containers[?state=='running'].name", data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ok {
+		t.Fatalf("expected ok")
+	}
+
+	want := []interface{}{"web", "cache"}
+	if !reflect.DeepEqual(val, want) {
+		t.Fatalf("expected %v, got %v", want, val)
+	}
+}
+
+func TestFilterNegation(t *testing.T) {
+	data := map[string]interface{}{
+		"containers": []interface{}{
+			map[string]interface{}{"name": "web", "state": "running"},
+			map[string]interface{}{"name": "db", "state": "stopped"},
+		},
+	}
+
+	val, ok, err := Eval("containers[?state!='running'].name", data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ok {
+		t.Fatalf("expected ok")
+	}
+
+	want := []interface{}{"db"}
+	if !reflect.DeepEqual(val, want) {
+		t.Fatalf("expected %v, got %v", want, val)
+	}
+}
+
+func TestFilterMatchingNothingIsEmptyNotNotFound(t *testing.T) {
+	data := map[string]interface{}{
+		"containers": []interface{}{
+			map[string]interface{}{"name": "web", "state": "stopped"},
+		},
+	}
+
+	val, ok, err := Eval("containers[?state=='running']", data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ok {
+		t.Fatalf("a filter matching nothing should still be ok, not not-found")
+	}
+
+	want := []interface{}{}
+	if !reflect.DeepEqual(val, want) {
+		t.Fatalf("expected empty slice, got %v", val)
+	}
+}
+
+func TestIndexAccess(t *testing.T) {
+	data := map[string]interface{}{
+		"things": []interface{}{"a", "b", "c"},
+	}
+
+	val, ok, err := Eval("things[1]", data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ok || val != "b" {
+		t.Fatalf("expected \"b\", got %v (ok=%v)", val, ok)
+	}
+}
+
+func TestNegativeIndexAccess(t *testing.T) {
+	data := map[string]interface{}{
+		"things": []interface{}{"a", "b", "c"},
+	}
+
+	val, ok, err := Eval("things[-1]", data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ok || val != "c" {
+		t.Fatalf("expected \"c\", got %v (ok=%v)", val, ok)
+	}
+}
+
+func TestOutOfRangeIndexIsNotFound(t *testing.T) {
+	data := map[string]interface{}{
+		"things": []interface{}{"a", "b", "c"},
+	}
+
+	_, ok, err := Eval("things[10]", data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ok {
+		t.Fatalf("expected not-found for an out-of-range index")
+	}
+}
+
+func TestWildcardOverMapIsSortedByKey(t *testing.T) {
+	data := map[string]interface{}{
+		"things": map[string]interface{}{
+			"z": 1,
+			"a": 2,
+			"m": 3,
+		},
+	}
+
+	val, ok, err := Eval("things[*]", data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ok {
+		t.Fatalf("expected ok")
+	}
+
+	want := []interface{}{2, 3, 1}
+	if !reflect.DeepEqual(val, want) {
+		t.Fatalf("expected %v sorted by key, got %v", want, val)
+	}
+}
+
+func TestMagicArrayKeyIndexing(t *testing.T) {
+	data := map[string]interface{}{
+		"things": []interface{}{
+			map[string]interface{}{"name": "asdf", "stuff": 42},
+			map[string]interface{}{"name": "zxcv", "stuff": 43},
+		},
+	}
+
+	val, ok, err := Eval("things.asdf.stuff", data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ok || val != 42 {
+		t.Fatalf("expected 42, got %v (ok=%v)", val, ok)
+	}
+}
+
+func TestUnsupportedSubscriptErrors(t *testing.T) {
+	_, _, err := Eval("things[?]", map[string]interface{}{})
+	if err == nil {
+		t.Fatalf("expected a parse error for a malformed filter")
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+// AnswersStore abstracts how client-specific answers are loaded, looked up
+// and reloaded. main.go used to talk to the package-level `answers Versions`
+// and loadAnswersFromFile directly; this lets a directory-tree-backed store
+// with its own incremental reload strategy sit behind the same API.
+//
+// Deviation from the original design, flagged here for visibility rather
+// than left as a quiet divergence: this interface was originally specced
+// with a Subscribe(chan<- Event) method for push-based watchers. It's
+// deliberately absent - watch.go's watchers don't subscribe to a store, they
+// wait on the global revision counter that Reload bumps and then re-read
+// their path through Get, so nothing ever called it. If a future store implementation needs per-path change events
+// instead of "something changed, go recheck", Subscribe is the thing to
+// bring back.
+type AnswersStore interface {
+	// Get resolves path for clientIp under version, with defaults already
+	// merged in - the same semantics Versions.Matching has today.
+	Get(version, clientIp string, path []string) (interface{}, bool)
+
+	// Versions lists the known version names.
+	Versions() []string
+
+	// Reload re-reads the backing data from disk.
+	Reload() error
+}
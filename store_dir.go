@@ -0,0 +1,435 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	fsnotify "gopkg.in/fsnotify.v1"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// indexKey identifies one precomputed, defaults-merged answer tree.
+type indexKey struct {
+	version  string
+	clientIp string
+}
+
+// dirStore is an AnswersStore backed by a directory tree laid out as
+// <dir>/<version>/default.yaml and <dir>/<version>/<clientIp>.yaml. It
+// watches the tree with fsnotify and, on a single file changing, re-merges
+// only that version/client entry instead of re-parsing everything - the
+// per-request lookup is then just a map hit plus a path walk.
+type dirStore struct {
+	dir string
+
+	lock     sync.RWMutex
+	index    map[indexKey]map[string]interface{}
+	defaults map[string]map[string]interface{}
+	versions map[string]bool
+
+	watcher *fsnotify.Watcher
+}
+
+func newDirStore(dir string) (*dirStore, error) {
+	s := &dirStore{
+		dir:      dir,
+		index:    map[indexKey]map[string]interface{}{},
+		defaults: map[string]map[string]interface{}{},
+		versions: map[string]bool{},
+	}
+
+	if err := s.reloadAll(); err != nil {
+		return nil, err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	s.watcher = watcher
+
+	if err := s.watchTree(); err != nil {
+		return nil, err
+	}
+
+	go s.run()
+
+	return s, nil
+}
+
+func (s *dirStore) watchTree() error {
+	return filepath.Walk(s.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return s.watcher.Add(path)
+		}
+		return nil
+	})
+}
+
+func (s *dirStore) run() {
+	for {
+		select {
+		case event, ok := <-s.watcher.Events:
+			if !ok {
+				return
+			}
+
+			// A brand-new version directory (e.g. rolling out answers for a
+			// new version) only shows up as a Create event on s.dir itself -
+			// the initial Walk in watchTree never saw it, so nothing is
+			// watching it yet. Start watching it and load what's already in
+			// it before falling through to the regular per-file handling.
+			if event.Op&fsnotify.Create != 0 {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if err := s.watcher.Add(event.Name); err != nil {
+						log.Errorf("Failed to watch new answers directory %s: %v", event.Name, err)
+					}
+					if err := s.loadVersionDir(event.Name); err != nil {
+						log.Errorf("Failed to load new answers directory %s: %v", event.Name, err)
+					}
+					continue
+				}
+			}
+
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) == 0 {
+				continue
+			}
+			if err := s.reloadFile(event.Name); err != nil {
+				log.Errorf("Failed to reload %s: %v", event.Name, err)
+			}
+		case err, ok := <-s.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Errorf("Answers directory watcher error: %v", err)
+		}
+	}
+}
+
+// loadVersionDir (re)loads every client file plus default.yaml under a
+// single version directory and merges the results into the index. It's used
+// to seed a version directory created after startup, which watchTree never
+// walked and s.reloadFile can't handle since it operates one file at a time.
+func (s *dirStore) loadVersionDir(dir string) (err error) {
+	version := filepath.Base(dir)
+
+	reloadCount.Inc()
+	defer func() {
+		if err != nil {
+			reloadErrorCount.Inc()
+		}
+	}()
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	defaultTree, err := readYAMLFile(filepath.Join(dir, DEFAULT_KEY+".yaml"))
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	s.versions[version] = true
+	s.defaults[version] = defaultTree
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != ".yaml" {
+			continue
+		}
+		client := strings.TrimSuffix(f.Name(), ".yaml")
+		if client == DEFAULT_KEY {
+			continue
+		}
+
+		tree, err := readYAMLFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			continue
+		}
+		s.index[indexKey{version, client}] = mergeClient(tree, defaultTree)
+	}
+	registeredClients.Set(float64(len(s.index)))
+	s.lock.Unlock()
+
+	lastReloadTimestamp.Set(float64(time.Now().Unix()))
+	bumpRevision()
+	return nil
+}
+
+// reloadFile incrementally re-merges just the version/client entry path
+// belongs to. A change to a version's default.yaml instead triggers a
+// re-merge of that one version, since every client under it is affected.
+func (s *dirStore) reloadFile(path string) error {
+	if filepath.Ext(path) != ".yaml" {
+		return nil
+	}
+
+	rel, err := filepath.Rel(s.dir, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return nil
+	}
+
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	if len(parts) != 2 {
+		return nil
+	}
+	version, client := parts[0], strings.TrimSuffix(parts[1], ".yaml")
+
+	reloadCount.Inc()
+
+	tree, err := readYAMLFile(path)
+	if err != nil {
+		reloadErrorCount.Inc()
+		return err
+	}
+
+	if client == DEFAULT_KEY {
+		s.lock.Lock()
+		s.versions[version] = true
+		s.defaults[version] = tree
+		s.lock.Unlock()
+
+		if err := s.remergeVersion(version); err != nil {
+			reloadErrorCount.Inc()
+			return err
+		}
+	} else {
+		s.lock.Lock()
+		s.versions[version] = true
+		if tree != nil {
+			s.index[indexKey{version, client}] = mergeClient(tree, s.defaults[version])
+		} else {
+			delete(s.index, indexKey{version, client})
+		}
+		s.lock.Unlock()
+	}
+
+	s.lock.RLock()
+	registeredClients.Set(float64(len(s.index)))
+	s.lock.RUnlock()
+
+	lastReloadTimestamp.Set(float64(time.Now().Unix()))
+	bumpRevision()
+	return nil
+}
+
+// remergeVersion rebuilds the index entries for every client under version
+// from its current default.yaml, used when that default file changes.
+func (s *dirStore) remergeVersion(version string) error {
+	dir := filepath.Join(s.dir, version)
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	defaults := s.defaults[version]
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".yaml" {
+			continue
+		}
+		client := strings.TrimSuffix(entry.Name(), ".yaml")
+		if client == DEFAULT_KEY {
+			continue
+		}
+
+		tree, err := readYAMLFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		s.index[indexKey{version, client}] = mergeClient(tree, defaults)
+	}
+
+	return nil
+}
+
+func (s *dirStore) reloadAll() (err error) {
+	reloadCount.Inc()
+	defer func() {
+		if err != nil {
+			reloadErrorCount.Inc()
+		}
+	}()
+
+	versionDirs, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	index := map[indexKey]map[string]interface{}{}
+	defaults := map[string]map[string]interface{}{}
+	versions := map[string]bool{}
+
+	for _, vd := range versionDirs {
+		if !vd.IsDir() {
+			continue
+		}
+		version := vd.Name()
+		versions[version] = true
+
+		versionDir := filepath.Join(s.dir, version)
+		files, err := ioutil.ReadDir(versionDir)
+		if err != nil {
+			return err
+		}
+
+		defaultTree, err := readYAMLFile(filepath.Join(versionDir, DEFAULT_KEY+".yaml"))
+		if err != nil {
+			return err
+		}
+		defaults[version] = defaultTree
+
+		for _, f := range files {
+			if f.IsDir() || filepath.Ext(f.Name()) != ".yaml" {
+				continue
+			}
+			client := strings.TrimSuffix(f.Name(), ".yaml")
+			if client == DEFAULT_KEY {
+				continue
+			}
+
+			tree, err := readYAMLFile(filepath.Join(versionDir, f.Name()))
+			if err != nil {
+				return err
+			}
+			index[indexKey{version, client}] = mergeClient(tree, defaultTree)
+		}
+	}
+
+	s.lock.Lock()
+	s.index = index
+	s.defaults = defaults
+	s.versions = versions
+	s.lock.Unlock()
+
+	lastReloadTimestamp.Set(float64(time.Now().Unix()))
+	registeredClients.Set(float64(len(index)))
+
+	return nil
+}
+
+// mergeClient returns client's tree with any keys missing from it filled in
+// from defaults - the same semantics as the file-backed store's
+// mergeDefaults, but computed once per client at load time rather than
+// walked on every request.
+func mergeClient(client map[string]interface{}, defaults map[string]interface{}) map[string]interface{} {
+	if defaults == nil {
+		return client
+	}
+	if client == nil {
+		client = map[string]interface{}{}
+	}
+
+	merged := make(map[string]interface{}, len(defaults)+len(client))
+	for k, v := range defaults {
+		merged[k] = v
+	}
+	for k, v := range client {
+		merged[k] = v
+	}
+	return merged
+}
+
+func readYAMLFile(path string) (map[string]interface{}, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var tree map[string]interface{}
+	if err := yaml.Unmarshal(bytes, &tree); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+func (s *dirStore) Get(version, clientIp string, path []string) (interface{}, bool) {
+	s.lock.RLock()
+	tree, ok := s.index[indexKey{version, clientIp}]
+	s.lock.RUnlock()
+	if !ok {
+		return nil, false
+	}
+
+	return walkPath(tree, path)
+}
+
+// walkPath descends a pre-merged tree the same way Versions.Matching does,
+// following MAGIC_ARRAY_KEY name-indexing into arrays.
+func walkPath(tree map[string]interface{}, path []string) (interface{}, bool) {
+	var cur interface{} = tree
+
+	for _, key := range path {
+		if key == "" {
+			continue
+		}
+
+		switch t := cur.(type) {
+		case map[string]interface{}:
+			v, ok := t[key]
+			if !ok {
+				return nil, false
+			}
+			cur = v
+		case []interface{}:
+			if i, err := strconv.Atoi(key); err == nil && i >= 0 && i < len(t) {
+				cur = t[i]
+				continue
+			}
+
+			found := false
+			for _, item := range t {
+				m, ok := item.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				if name, ok := m[MAGIC_ARRAY_KEY]; ok && fmt.Sprintf("%v", name) == key {
+					cur = item
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, false
+			}
+		default:
+			return nil, false
+		}
+	}
+
+	return cur, true
+}
+
+func (s *dirStore) Versions() []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	out := make([]string, 0, len(s.versions))
+	for v := range s.versions {
+		out = append(out, v)
+	}
+	return out
+}
+
+func (s *dirStore) Reload() error {
+	if err := s.reloadAll(); err != nil {
+		return err
+	}
+	bumpRevision()
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeYAML(t *testing.T, path, content string) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
+
+func TestDirStoreMergesDefaultsAndExcludesDefaultFromIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dirstore")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	versionDir := filepath.Join(dir, "2026-07-30")
+	if err := os.MkdirAll(versionDir, 0755); err != nil {
+		t.Fatalf("failed to create version dir: %v", err)
+	}
+	writeYAML(t, filepath.Join(versionDir, "default.yaml"), "region: us-west\n")
+	writeYAML(t, filepath.Join(versionDir, "10.0.0.1.yaml"), "hostname: host-a\n")
+
+	s, err := newDirStore(dir)
+	if err != nil {
+		t.Fatalf("newDirStore failed: %v", err)
+	}
+	defer s.watcher.Close()
+
+	if val, ok := s.Get("2026-07-30", "10.0.0.1", []string{"hostname"}); !ok || val != "host-a" {
+		t.Fatalf("expected hostname=host-a, got %v (ok=%v)", val, ok)
+	}
+
+	if val, ok := s.Get("2026-07-30", "10.0.0.1", []string{"region"}); !ok || val != "us-west" {
+		t.Fatalf("expected the default region to be merged in, got %v (ok=%v)", val, ok)
+	}
+
+	if _, ok := s.index[indexKey{"2026-07-30", DEFAULT_KEY}]; ok {
+		t.Fatalf("the default entry itself should not appear in the index")
+	}
+}
+
+// TestDirStoreWatchesVersionDirectoryCreatedAfterStartup guards against the
+// watch-set gap: a version directory created after the initial Walk used to
+// never get picked up without a full process restart.
+func TestDirStoreWatchesVersionDirectoryCreatedAfterStartup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dirstore")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	seedDir := filepath.Join(dir, "2026-01-01")
+	if err := os.MkdirAll(seedDir, 0755); err != nil {
+		t.Fatalf("failed to create version dir: %v", err)
+	}
+	writeYAML(t, filepath.Join(seedDir, "default.yaml"), "region: us-west\n")
+
+	s, err := newDirStore(dir)
+	if err != nil {
+		t.Fatalf("newDirStore failed: %v", err)
+	}
+	defer s.watcher.Close()
+
+	newVersionDir := filepath.Join(dir, "2026-08-01")
+	if err := os.MkdirAll(newVersionDir, 0755); err != nil {
+		t.Fatalf("failed to create new version dir: %v", err)
+	}
+	writeYAML(t, filepath.Join(newVersionDir, "10.0.0.2.yaml"), "hostname: host-b\n")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if val, ok := s.Get("2026-08-01", "10.0.0.2", []string{"hostname"}); ok && val == "host-b" {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("new version directory was never picked up by the watcher")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+// fileStore is the original AnswersStore: a single YAML file, fully
+// re-parsed and re-merged with defaults by loadAnswersFromFile on every
+// Reload.
+type fileStore struct {
+	file string
+}
+
+func newFileStore(file string) *fileStore {
+	return &fileStore{file: file}
+}
+
+func (s *fileStore) Get(version, clientIp string, path []string) (interface{}, bool) {
+	return answers.Matching(version, clientIp, path)
+}
+
+func (s *fileStore) Versions() []string {
+	return answers.Versions()
+}
+
+func (s *fileStore) Reload() error {
+	_, err := loadAnswersFromFile(s.file)
+	return err
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+var (
+	tlsListenAddr = flag.String("tlsListen", "", "Address to listen to for HTTPS (TCP); HTTPS is disabled if empty")
+	tlsCertFile   = flag.String("tlsCert", "", "Path to the TLS certificate file")
+	tlsKeyFile    = flag.String("tlsKey", "", "Path to the TLS private key file")
+
+	// shutdownTimeout is the floor for how long graceful shutdown waits for
+	// in-flight requests to drain - see drainTimeout, which raises it to
+	// cover a long-poll/SSE watcher sitting at maxWait.
+	shutdownTimeout = 30 * time.Second
+
+	certStore atomic.Value // holds *tls.Certificate
+)
+
+// loadCertificate (re)reads the configured cert/key pair from disk and
+// atomically swaps it in. It's a no-op when -tlsCert/-tlsKey aren't set, so
+// it's safe to call unconditionally from the regular reload path.
+func loadCertificate() error {
+	if *tlsCertFile == "" && *tlsKeyFile == "" {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(*tlsCertFile, *tlsKeyFile)
+	if err != nil {
+		return err
+	}
+
+	certStore.Store(&cert)
+	return nil
+}
+
+func getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert, _ := certStore.Load().(*tls.Certificate)
+	if cert == nil {
+		return nil, fmt.Errorf("no TLS certificate loaded")
+	}
+	return cert, nil
+}
+
+// startTLSListener starts the HTTPS listener when -tlsListen is set,
+// returning nil otherwise. The certificate is re-read on every reload via
+// loadAnswers, so rotating it on disk and sending SIGHUP (or POSTing
+// /v1/reload) is enough to pick up new certs without dropping connections.
+func startTLSListener() *http.Server {
+	if *tlsListenAddr == "" {
+		return nil
+	}
+
+	if *tlsCertFile == "" || *tlsKeyFile == "" {
+		log.Fatalf("-tlsListen requires both -tlsCert and -tlsKey to be set")
+	}
+
+	if err := loadCertificate(); err != nil {
+		log.Fatalf("Failed to load TLS certificate: %v", err)
+	}
+
+	server := &http.Server{
+		Addr:    *tlsListenAddr,
+		Handler: router,
+		TLSConfig: &tls.Config{
+			GetCertificate: getCertificate,
+		},
+	}
+
+	go func() {
+		log.Info("Listening for HTTPS on ", *tlsListenAddr)
+		if err := server.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	return server
+}
+
+// drainTimeout returns how long graceful shutdown should wait for in-flight
+// requests to finish. It's at least shutdownTimeout, but never shorter than
+// maxWait plus a grace period, so a long-poll/SSE watcher that's legitimately
+// still inside its wait gets a chance to return on its own instead of being
+// hard-killed by the os.Exit that follows Shutdown.
+func drainTimeout() time.Duration {
+	if floor := maxWait + 5*time.Second; floor > shutdownTimeout {
+		return floor
+	}
+	return shutdownTimeout
+}
+
+// watchShutdown drains in-flight connections on SIGTERM/SIGINT by calling
+// Shutdown on every listener that's running, then exits.
+func watchShutdown() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGTERM, syscall.SIGINT)
+
+	go func() {
+		sig := <-c
+		log.Infof("Received %s, draining connections", sig)
+
+		ctx, cancel := context.WithTimeout(context.Background(), drainTimeout())
+		defer cancel()
+
+		for _, s := range []*http.Server{mainServer, tlsServer, reloadServer} {
+			if s == nil {
+				continue
+			}
+			if err := s.Shutdown(ctx); err != nil {
+				log.Warnf("Error shutting down %s: %v", s.Addr, err)
+			}
+		}
+
+		os.Exit(0)
+	}()
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"crypto/tls"
+	"testing"
+	"time"
+)
+
+func TestDrainTimeoutUsesFloorWhenWaitTimeoutIsShort(t *testing.T) {
+	origMaxWait := maxWait
+	defer func() { maxWait = origMaxWait }()
+
+	maxWait = 5 * time.Second
+	if got := drainTimeout(); got != shutdownTimeout {
+		t.Fatalf("expected the %s floor, got %s", shutdownTimeout, got)
+	}
+}
+
+func TestDrainTimeoutExtendsPastMaxWait(t *testing.T) {
+	origMaxWait := maxWait
+	defer func() { maxWait = origMaxWait }()
+
+	maxWait = 5 * time.Minute
+	want := maxWait + 5*time.Second
+	if got := drainTimeout(); got != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+}
+
+func TestGetCertificateErrorsWhenNoneLoaded(t *testing.T) {
+	if cert, _ := certStore.Load().(*tls.Certificate); cert != nil {
+		t.Skip("a certificate is already loaded in this process")
+	}
+
+	if _, err := getCertificate(nil); err == nil {
+		t.Fatalf("expected an error when no certificate has been loaded")
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/ibuildthecloud/rancher-metadata/query"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// maxWait bounds how long a long-poll or SSE connection waits for a change
+// before the watcher gives up: a long-poll returns whatever value is current,
+// an SSE stream ends. Each SSE event starts a new wait.
+var maxWait = 60 * time.Second
+
+var (
+	revisionLock sync.Mutex
+	revisionCond = sync.NewCond(&revisionLock)
+	revision     int64
+)
+
+// bumpRevision advances the global revision counter and wakes up every
+// goroutine blocked in waitForChange. It's called once per successful
+// answers reload.
+func bumpRevision() {
+	revisionLock.Lock()
+	revision++
+	revisionCond.Broadcast()
+	revisionLock.Unlock()
+}
+
+func currentRevision() int64 {
+	revisionLock.Lock()
+	defer revisionLock.Unlock()
+	return revision
+}
+
+// wantsWatch reports whether the request is asking to long-poll or
+// subscribe via Server-Sent-Events instead of getting an immediate answer.
+func wantsWatch(req *http.Request) bool {
+	if req.URL.Query().Get("wait") == "true" {
+		return true
+	}
+	return acceptsEventStream(req)
+}
+
+func acceptsEventStream(req *http.Request) bool {
+	accept := req.Header.Get("Accept")
+	return accept == "text/event-stream" || accept == "text/event-stream; charset=utf-8"
+}
+
+// waitForChange blocks until the value at version/clientIp/pathSegments
+// (after projecting it through q, if set) actually differs from
+// (lastVal, lastOk), the request's context is done, or maxWait elapses. A
+// revision bump alone isn't enough to return - it just means *some* client's
+// answers changed - so each wakeup re-resolves this specific path and loops
+// if it's still the same. The final bool reports whether the returned value
+// actually changed; false means the deadline passed or the request's
+// context ended first.
+func waitForChange(req *http.Request, startIndex int64, version, clientIp string, pathSegments []string, q *query.Query, lastVal interface{}, lastOk bool) (interface{}, bool, int64, bool, error) {
+	deadline := time.Now().Add(maxWait)
+	idx := startIndex
+
+	for {
+		idx = blockForRevisionAfter(req, idx, deadline)
+
+		if req.Context().Err() != nil {
+			return lastVal, lastOk, idx, false, nil
+		}
+
+		val, ok, err := resolveValue(version, clientIp, pathSegments, q)
+		if err != nil {
+			return nil, false, idx, false, err
+		}
+
+		if !reflect.DeepEqual(val, lastVal) || ok != lastOk {
+			return val, ok, idx, true, nil
+		}
+
+		if !time.Now().Before(deadline) {
+			return val, ok, idx, false, nil
+		}
+	}
+}
+
+// blockForRevisionAfter blocks until the global revision exceeds startIndex,
+// the request's context is done, or deadline passes, and returns the
+// revision observed.
+func blockForRevisionAfter(req *http.Request, startIndex int64, deadline time.Time) int64 {
+	done := make(chan struct{})
+
+	go func() {
+		revisionLock.Lock()
+		for revision <= startIndex && time.Now().Before(deadline) && req.Context().Err() == nil {
+			waitWithDeadline(revisionCond, deadline)
+		}
+		revisionLock.Unlock()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-req.Context().Done():
+		// Wake the helper goroutine right away instead of leaving it
+		// parked in Cond.Wait until the next reload or its own deadline
+		// timer fires - at scale, disconnects are frequent and each one
+		// left parked is a goroutine held for up to maxWait.
+		revisionLock.Lock()
+		revisionCond.Broadcast()
+		revisionLock.Unlock()
+		<-done
+	}
+
+	return currentRevision()
+}
+
+// waitWithDeadline wakes the condition variable's waiter no later than the
+// deadline, even if no broadcast ever arrives, by racing a
+// timer against Cond.Wait on a helper goroutine.
+func waitWithDeadline(cond *sync.Cond, deadline time.Time) {
+	timeout := time.Until(deadline)
+	if timeout <= 0 {
+		return
+	}
+
+	timer := time.AfterFunc(timeout, func() {
+		cond.L.Lock()
+		cond.Broadcast()
+		cond.L.Unlock()
+	})
+	defer timer.Stop()
+
+	cond.Wait()
+}
+
+func watchMetadata(w http.ResponseWriter, req *http.Request, version, clientIp string, pathSegments []string, displayKey string, q *query.Query) {
+	activeWatchers.Inc()
+	defer activeWatchers.Dec()
+
+	sse := acceptsEventStream(req)
+
+	startIndex := currentRevision()
+	if idx, err := strconv.ParseInt(req.URL.Query().Get("index"), 10, 64); err == nil {
+		startIndex = idx
+	}
+
+	lastVal, lastOk, err := resolveValue(version, clientIp, pathSegments, q)
+	if err != nil {
+		respondError(w, req, "Invalid query: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var flusher http.Flusher
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher, _ = w.(http.Flusher)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	for {
+		val, ok, idx, changed, err := waitForChange(req, startIndex, version, clientIp, pathSegments, q, lastVal, lastOk)
+
+		if req.Context().Err() != nil {
+			log.WithFields(log.Fields{"version": version, "client": clientIp}).Debugf("Watch disconnected: %s", displayKey)
+			return
+		}
+
+		if err != nil {
+			respondError(w, req, "Invalid query: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !sse {
+			// Long-poll: on an actual change or on timing out, return
+			// whatever is current - but a vanished path is still a 404,
+			// the same as the non-watch path.
+			if !ok {
+				respondError(w, req, "Not found", http.StatusNotFound)
+				return
+			}
+			respondSuccess(w, req, val)
+			return
+		}
+
+		startIndex = idx
+		lastVal, lastOk = val, ok
+
+		if !changed {
+			// Deadline passed with no real change to this path; end the
+			// stream rather than sending a spurious event.
+			return
+		}
+
+		if !ok {
+			// The path disappeared; nothing to project yet. Keep
+			// watching in case it comes back, but don't claim a change.
+			continue
+		}
+
+		writeEvent(w, req, val)
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, req *http.Request, val interface{}) {
+	fmt.Fprint(w, "event: change\ndata: ")
+
+	switch contentType(req) {
+	case ContentJSON:
+		bytes, err := json.Marshal(val)
+		if err == nil {
+			w.Write(bytes)
+		}
+	case ContentYAML:
+		bytes, err := yaml.Marshal(val)
+		if err == nil {
+			fmt.Fprint(w, string(bytes))
+		}
+	default:
+		respondText(w, req, val)
+	}
+
+	fmt.Fprint(w, "\n\n")
+}
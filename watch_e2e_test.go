@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeStore is a minimal AnswersStore test double: a single version/client
+// entry whose value can be swapped out mid-test to drive a long-poll/SSE
+// watcher the way a real reload would.
+type fakeStore struct {
+	mu  sync.Mutex
+	val interface{}
+	ok  bool
+}
+
+func (s *fakeStore) set(val interface{}, ok bool) {
+	s.mu.Lock()
+	s.val, s.ok = val, ok
+	s.mu.Unlock()
+	bumpRevision()
+}
+
+func (s *fakeStore) Get(version, clientIp string, path []string) (interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.val, s.ok
+}
+
+func (s *fakeStore) Versions() []string { return []string{"latest"} }
+func (s *fakeStore) Reload() error      { return nil }
+
+// withWatchServer starts an httptest.Server whose only route runs
+// watchMetadata exactly the way metadata() does, wired to a fresh fakeStore,
+// and returns it alongside the store so the test can mutate values. It also
+// swaps in a short maxWait for the duration of the test.
+func withWatchServer(t *testing.T, wait time.Duration) (*httptest.Server, *fakeStore) {
+	t.Helper()
+
+	origStore, origMaxWait := store, maxWait
+	fs := &fakeStore{val: "bar", ok: true}
+	store = fs
+	maxWait = wait
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		watchMetadata(w, req, "latest", "10.0.0.1", []string{"foo"}, "/foo", nil)
+	}))
+
+	t.Cleanup(func() {
+		srv.Close()
+		store, maxWait = origStore, origMaxWait
+	})
+
+	return srv, fs
+}
+
+func TestLongPollReturnsNewValueOnChange(t *testing.T) {
+	srv, fs := withWatchServer(t, time.Minute)
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	respCh := make(chan *http.Response, 1)
+	go func() {
+		resp, err := client.Get(srv.URL + "?wait=true")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		respCh <- resp
+	}()
+
+	// Give the long-poll request time to actually block before changing the
+	// value out from under it.
+	time.Sleep(100 * time.Millisecond)
+	fs.set("baz", true)
+
+	select {
+	case resp := <-respCh:
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200, got %d", resp.StatusCode)
+		}
+		body := make([]byte, 16)
+		n, _ := resp.Body.Read(body)
+		if got := string(body[:n]); got != "baz" {
+			t.Fatalf("expected body %q, got %q", "baz", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("long-poll request never returned after the value changed")
+	}
+}
+
+func TestLongPollReturns404WhenPathVanishes(t *testing.T) {
+	srv, fs := withWatchServer(t, 50*time.Millisecond)
+	fs.set(nil, false)
+
+	resp, err := http.Get(srv.URL + "?wait=true")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for a vanished watch target, got %d", resp.StatusCode)
+	}
+}
+
+func TestSSEEmitsChangeEvent(t *testing.T) {
+	srv, fs := withWatchServer(t, time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected an SSE content type, got %q", ct)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	fs.set("baz", true)
+
+	reader := bufio.NewReader(resp.Body)
+	lines := make(chan string, 2)
+	go func() {
+		for {
+			line, err := reader.ReadString('\n')
+			if line != "" {
+				lines <- line
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	var event, data string
+	deadline := time.After(5 * time.Second)
+	for event == "" || data == "" {
+		select {
+		case line := <-lines:
+			switch {
+			case line == "event: change\n":
+				event = line
+			case line == "data: baz\n":
+				data = line
+			}
+		case <-deadline:
+			t.Fatalf("never saw a complete event: change / data: baz frame")
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWantsWatch(t *testing.T) {
+	req := httptest.NewRequest("GET", "/latest/self?wait=true", nil)
+	if !wantsWatch(req) {
+		t.Fatalf("expected wait=true to request a watch")
+	}
+
+	plain := httptest.NewRequest("GET", "/latest/self", nil)
+	if wantsWatch(plain) {
+		t.Fatalf("expected a plain request not to request a watch")
+	}
+}
+
+func TestAcceptsEventStream(t *testing.T) {
+	req := httptest.NewRequest("GET", "/latest/self", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	if !wantsWatch(req) {
+		t.Fatalf("expected an SSE Accept header to request a watch")
+	}
+}
+
+// TestBlockForRevisionAfterReturnsPromptlyOnDisconnect guards against the
+// parked-goroutine leak: cancelling the request's context should wake the
+// helper goroutine immediately instead of leaving it in Cond.Wait until the
+// next reload or its own deadline timer, up to maxWait later.
+func TestBlockForRevisionAfterReturnsPromptlyOnDisconnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest("GET", "/latest/self?wait=true", nil).WithContext(ctx)
+
+	deadline := time.Now().Add(time.Minute)
+
+	done := make(chan struct{})
+	go func() {
+		blockForRevisionAfter(req, currentRevision(), deadline)
+		close(done)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("blockForRevisionAfter did not return promptly after the request context was cancelled")
+	}
+}